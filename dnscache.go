@@ -0,0 +1,157 @@
+package libv2ray
+
+import (
+	"context"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"golang.org/x/sync/singleflight"
+)
+
+// defaultDNSCacheTTL is how long a resolved answer is served from the cache
+// before it expires and is re-resolved synchronously. A background refresh
+// starts earlier, see defaultDNSSoftTTLRatio.
+const defaultDNSCacheTTL = 10 * time.Minute
+
+// defaultDNSSoftTTLRatio controls when a cache hit starts a background
+// refresh instead of just being returned: once defaultDNSSoftTTLRatio of the TTL has
+// elapsed, the stale-but-still-valid entry is returned immediately while a
+// refresh is kicked off to replace it for next time.
+const defaultDNSSoftTTLRatio = 0.5
+
+// Resolver is the lookup surface ProtectedDialer depends on. *net.Resolver
+// satisfies it, and so does *dnsCache, so newDNSCache can wrap either a plain
+// system resolver or a secure one behind the same cache.
+type Resolver interface {
+	LookupIPAddr(ctx context.Context, host string) ([]net.IPAddr, error)
+	LookupPort(ctx context.Context, network, service string) (int, error)
+}
+
+// SetResolver overrides the Resolver used by lookupAddr. Passing a dnsCache
+// built with newDNSCache(r, ttl) layers TTL caching and de-duplication on
+// top of an arbitrary underlying Resolver.
+func (d *ProtectedDialer) SetResolver(r Resolver) {
+	d.resolver = r
+}
+
+// dnsCacheEntry is one cached answer for a host. ips/expires/soft are set
+// once at creation and never mutated; stale is the only field touched after
+// that (by Forget, from a goroutine unrelated to whoever is reading the
+// entry), so it's an atomic.Bool rather than a plain bool.
+type dnsCacheEntry struct {
+	ips     []net.IPAddr
+	expires time.Time // entry is dropped and re-resolved synchronously past this
+	soft    time.Time // entry triggers an async refresh (but is still served) past this
+	stale   atomic.Bool // set by Forget/NextIP to force a synchronous refresh next lookup
+}
+
+// dnsCache is a small in-process DNS cache modeled on Tailscale's
+// dnscache.Resolver: it serves fresh answers immediately, coalesces
+// concurrent misses for the same host with singleflight, and refreshes
+// soft-expired entries in the background instead of blocking callers.
+type dnsCache struct {
+	resolver Resolver
+	ttl      time.Duration
+
+	mu      sync.Mutex
+	entries map[string]*dnsCacheEntry
+
+	sf singleflight.Group
+}
+
+// newDNSCache wraps resolver with a TTL-aware cache. A ttl <= 0 uses
+// defaultDNSCacheTTL.
+func newDNSCache(resolver Resolver, ttl time.Duration) *dnsCache {
+	if ttl <= 0 {
+		ttl = defaultDNSCacheTTL
+	}
+	return &dnsCache{
+		resolver: resolver,
+		ttl:      ttl,
+		entries:  make(map[string]*dnsCacheEntry),
+	}
+}
+
+// LookupPort is not cached; it's a tiny, rarely-varying lookup (service name
+// to port number) so it's passed straight through to the underlying resolver.
+func (c *dnsCache) LookupPort(ctx context.Context, network, service string) (int, error) {
+	return c.resolver.LookupPort(ctx, network, service)
+}
+
+// LookupIPAddr returns a cached answer when one is fresh enough, otherwise
+// resolves (coalesced via singleflight) and caches the result.
+func (c *dnsCache) LookupIPAddr(ctx context.Context, host string) ([]net.IPAddr, error) {
+	now := time.Now()
+
+	c.mu.Lock()
+	entry, ok := c.entries[host]
+	c.mu.Unlock()
+
+	if ok && !entry.stale.Load() && now.Before(entry.expires) {
+		if now.After(entry.soft) {
+			// still valid, but due for a refresh; don't make the caller wait for it.
+			go c.refresh(host)
+		}
+		return entry.ips, nil
+	}
+
+	v, err, _ := c.sf.Do(host, func() (interface{}, error) {
+		return c.lookupAndStore(ctx, host)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return v.([]net.IPAddr), nil
+}
+
+func (c *dnsCache) refresh(host string) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	c.sf.Do(host, func() (interface{}, error) {
+		return c.lookupAndStore(ctx, host)
+	})
+}
+
+// ttlHinter is implemented by resolvers (e.g. the secure DNS client) that
+// know the actual TTL of the answer they just returned, letting dnsCache
+// honor it instead of applying its own default ttl.
+type ttlHinter interface {
+	ttlHint(host string) (time.Duration, bool)
+}
+
+func (c *dnsCache) lookupAndStore(ctx context.Context, host string) ([]net.IPAddr, error) {
+	ips, err := c.resolver.LookupIPAddr(ctx, host)
+	if err != nil {
+		return nil, err
+	}
+
+	ttl := c.ttl
+	if hinter, ok := c.resolver.(ttlHinter); ok {
+		if hint, ok := hinter.ttlHint(host); ok && hint > 0 {
+			ttl = hint
+		}
+	}
+
+	now := time.Now()
+	c.mu.Lock()
+	c.entries[host] = &dnsCacheEntry{
+		ips:     ips,
+		expires: now.Add(ttl),
+		soft:    now.Add(time.Duration(float64(ttl) * defaultDNSSoftTTLRatio)),
+	}
+	c.mu.Unlock()
+
+	return ips, nil
+}
+
+// Forget marks the cached entry for host (if any) stale, forcing the next
+// LookupIPAddr to resolve synchronously instead of serving a stale answer.
+func (c *dnsCache) Forget(host string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if entry, ok := c.entries[host]; ok {
+		entry.stale.Store(true)
+	}
+}
@@ -8,6 +8,7 @@ import (
 	"net"
 	"os"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"golang.org/x/sys/unix"
@@ -17,6 +18,10 @@ import (
 	v2internet "github.com/xtls/xray-core/transport/internet"
 )
 
+// defaultFallbackDelay is the Happy-Eyeballs (RFC 8305) style stagger between
+// launching successive dial attempts when several candidate IPs are available.
+const defaultFallbackDelay = 250 * time.Millisecond
+
 type protectSet interface {
 	Protect(int) bool
 }
@@ -28,6 +33,11 @@ type resolved struct {
 	ipIdx        uint8
 	ipLock       sync.Mutex
 	lastSwitched time.Time
+
+	// onStale, if set, is called whenever NextIP is asked to move on from the
+	// current IP, so the cache behind it (if any) knows this answer turned
+	// out bad and should be re-resolved rather than served again as-is.
+	onStale func()
 }
 
 // NextIP switch to another resolved result.
@@ -35,6 +45,10 @@ type resolved struct {
 // may cause idx keep switching,
 // but that's an outside error can hardly handled here
 func (r *resolved) NextIP() {
+	if r.onStale != nil {
+		r.onStale()
+	}
+
 	r.ipLock.Lock()
 	defer r.ipLock.Unlock()
 
@@ -70,11 +84,27 @@ func (r *resolved) currentIP() net.IP {
 	return nil
 }
 
+// setCurrentIP points ipIdx at the given IP, if present, so that a winning
+// address picked by a concurrent dial becomes the one non-concurrent callers
+// (e.g. currentIP) keep using afterwards. It does not apply the NextIP
+// throttle since it's not reacting to a failure.
+func (r *resolved) setCurrentIP(ip net.IP) {
+	r.ipLock.Lock()
+	defer r.ipLock.Unlock()
+	for i, cur := range r.IPs {
+		if cur.Equal(ip) {
+			r.ipIdx = uint8(i)
+			return
+		}
+	}
+}
+
 // NewPreotectedDialer ...
 func NewPreotectedDialer(p protectSet) *ProtectedDialer {
 	d := &ProtectedDialer{
-		// prefer native lookup on Android
-		resolver:   &net.Resolver{PreferGo: false},
+		// prefer native lookup on Android, wrapped in a TTL cache so repeated
+		// "domestic" dials don't each pay for a fresh lookup.
+		resolver:   newDNSCache(&net.Resolver{PreferGo: false}, defaultDNSCacheTTL),
 		protectSet: p,
 	}
 	return d
@@ -82,18 +112,49 @@ func NewPreotectedDialer(p protectSet) *ProtectedDialer {
 
 // ProtectedDialer ...
 type ProtectedDialer struct {
-	currentServer string
-	resolveChan   chan struct{}
-	preferIPv6    bool
+	currentServer     string
+	resolveChan       chan struct{}
+	preferIPv6        bool
+	reresolveInterval time.Duration
 
-	vServer  *resolved
-	resolver *net.Resolver
+	// vServer is read by Dial (called concurrently per-connection by
+	// xray-core) while PrepareDomain/reresolveLoop replace it from their own
+	// goroutine, so it's an atomic.Pointer rather than a plain field.
+	vServer  atomic.Pointer[resolved]
+	resolver Resolver
+
+	concurrentDial bool
+	fallbackDelay  time.Duration
+	interfaceName  string
 
 	protectSet
 }
 
+// SetReresolveInterval makes PrepareDomain periodically re-resolve the
+// prepared v2ray server in the background, so long-lived sessions pick up
+// DNS changes instead of being pinned to the IPs seen at startup. An
+// interval <= 0 disables periodic re-resolution (the default).
+func (d *ProtectedDialer) SetReresolveInterval(interval time.Duration) {
+	d.reresolveInterval = interval
+}
+
+// SetConcurrentDial enables or disables Happy-Eyeballs style concurrent
+// dialing of every known IP for the prepared v2ray server. When disabled,
+// Dial falls back to the classic one-IP-at-a-time behavior driven by
+// resolved.NextIP.
+func (d *ProtectedDialer) SetConcurrentDial(enabled bool) {
+	d.concurrentDial = enabled
+}
+
+// SetFallbackDelay sets the stagger between launching successive dial
+// attempts when SetConcurrentDial is enabled. A value <= 0 resets it to
+// defaultFallbackDelay.
+func (d *ProtectedDialer) SetFallbackDelay(fallbackDelay time.Duration) {
+	d.fallbackDelay = fallbackDelay
+}
+
 func (d *ProtectedDialer) IsVServerReady() bool {
-	return (d.vServer != nil)
+	return d.vServer.Load() != nil
 }
 
 func (d *ProtectedDialer) PrepareResolveChan() {
@@ -153,6 +214,9 @@ func (d *ProtectedDialer) lookupAddr(addr string) (*resolved, error) {
 		IPs:    IPs,
 		Port:   portnum,
 	}
+	if cache, ok := d.resolver.(*dnsCache); ok {
+		rs.onStale = func() { cache.Forget(host) }
+	}
 
 	return rs, nil
 }
@@ -184,13 +248,42 @@ func (d *ProtectedDialer) PrepareDomain(domainName string, closeCh <-chan struct
 			continue
 		}
 
-		d.vServer = resolved
+		d.vServer.Store(resolved)
 		log.Printf("Prepare Result:\n Domain: %s\n Port: %d\n IPs: %v\n",
 			resolved.domain, resolved.Port, resolved.IPs)
+
+		if d.reresolveInterval > 0 {
+			go d.reresolveLoop(domainName, closeCh)
+		}
 		return
 	}
 }
 
+// reresolveLoop periodically re-resolves domainName and swaps it in as the
+// new vServer, so a long-lived session follows DNS changes for the v2ray
+// server instead of being stuck with whatever IPs PrepareDomain first saw.
+func (d *ProtectedDialer) reresolveLoop(domainName string, closeCh <-chan struct{}) {
+	ticker := time.NewTicker(d.reresolveInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-closeCh:
+			return
+		case <-ticker.C:
+		}
+
+		resolved, err := d.lookupAddr(domainName)
+		if err != nil {
+			log.Printf("reresolveLoop err: %v\n", err)
+			continue
+		}
+		d.vServer.Store(resolved)
+		log.Printf("reresolveLoop Result:\n Domain: %s\n Port: %d\n IPs: %v\n",
+			resolved.domain, resolved.Port, resolved.IPs)
+	}
+}
+
 func (d *ProtectedDialer) getFd(network v2net.Network) (fd int, err error) {
 	switch network {
 	case v2net.Network_TCP:
@@ -219,26 +312,39 @@ func (d *ProtectedDialer) Dial(ctx context.Context,
 	// try to connect fixed IP if multiple IP parsed from domain,
 	// and switch to next IP if error occurred
 	if Address == d.currentServer {
-		if d.vServer == nil {
+		vServer := d.vServer.Load()
+		if vServer == nil {
 			log.Println("Dial pending prepare  ...", Address)
 			<-d.resolveChan
 
 			// user may close connection during PrepareDomain,
 			// fast return release resources.
-			if d.vServer == nil {
+			vServer = d.vServer.Load()
+			if vServer == nil {
 				return nil, fmt.Errorf("fail to prepare domain %s", d.currentServer)
 			}
 		}
 
+		if d.concurrentDial && len(vServer.IPs) > 1 {
+			conn, winIP, err := d.dialParallel(ctx, dest.Network, vServer.IPs, vServer.Port, sockopt)
+			if err != nil {
+				vServer.NextIP()
+				return nil, err
+			}
+			vServer.setCurrentIP(winIP)
+			log.Printf("Using Prepared (concurrent): %s", winIP)
+			return conn, nil
+		}
+
 		fd, err := d.getFd(dest.Network)
 		if err != nil {
 			return nil, err
 		}
 
-		curIP := d.vServer.currentIP()
-		conn, err := d.fdConn(ctx, curIP, d.vServer.Port, fd)
+		curIP := vServer.currentIP()
+		conn, err := d.fdConnNetwork(ctx, dest.Network, curIP, vServer.Port, fd, sockopt)
 		if err != nil {
-			d.vServer.NextIP()
+			vServer.NextIP()
 			return nil, err
 		}
 		log.Printf("Using Prepared: %s", curIP)
@@ -259,13 +365,27 @@ func (d *ProtectedDialer) Dial(ctx context.Context,
 
 	// use the first resolved address.
 	// the result IP may vary, eg: IPv6 addrs comes first if client has ipv6 address
-	return d.fdConn(ctx, resolved.IPs[0], resolved.Port, fd)
+	return d.fdConnNetwork(ctx, dest.Network, resolved.IPs[0], resolved.Port, fd, sockopt)
+}
+
+func (d *ProtectedDialer) fdConn(ctx context.Context, ip net.IP, port int, fd int, sockopt *v2internet.SocketConfig) (net.Conn, error) {
+	return d.fdConnNetwork(ctx, v2net.Network_TCP, ip, port, fd, sockopt)
 }
 
-func (d *ProtectedDialer) fdConn(ctx context.Context, ip net.IP, port int, fd int) (net.Conn, error) {
+// fdConnNetwork is fdConn plus the network type, needed to pick the file
+// wrapper that actually matches the socket: net.FileConn for TCP, and
+// net.FilePacketConn for a connected UDP socket so callers get a real
+// *net.UDPConn (which also satisfies net.Conn) instead of net.FileConn's
+// generic wrapper.
+func (d *ProtectedDialer) fdConnNetwork(ctx context.Context, network v2net.Network, ip net.IP, port int, fd int, sockopt *v2internet.SocketConfig) (net.Conn, error) {
 
 	defer unix.Close(fd)
 
+	if err := d.applySocketConfig(network, fd, sockopt); err != nil {
+		log.Printf("fdConn applySocketConfig, Close Fd: %d Err: %v", fd, err)
+		return nil, err
+	}
+
 	// call android VPN service to "protect" the fd connecting straight out
 	if !d.Protect(fd) {
 		log.Printf("fdConn fail to protect, Close Fd: %d", fd)
@@ -290,6 +410,22 @@ func (d *ProtectedDialer) fdConn(ctx context.Context, ip net.IP, port int, fd in
 
 	defer file.Close()
 	//Closing conn does not affect file, and closing file does not affect conn.
+	if network == v2net.Network_UDP {
+		pc, err := net.FilePacketConn(file)
+		if err != nil {
+			log.Printf("fdConn FilePacketConn Close Fd: %d Err: %v", fd, err)
+			return nil, err
+		}
+		// a connected SOCK_DGRAM fd yields a *net.UDPConn, which implements
+		// net.Conn as well as net.PacketConn.
+		conn, ok := pc.(net.Conn)
+		if !ok {
+			pc.Close()
+			return nil, errors.New("fdConn: connected UDP socket did not yield a net.Conn")
+		}
+		return conn, nil
+	}
+
 	conn, err := net.FileConn(file)
 	if err != nil {
 		log.Printf("fdConn FileConn Close Fd: %d Err: %v", fd, err)
@@ -298,3 +434,121 @@ func (d *ProtectedDialer) fdConn(ctx context.Context, ip net.IP, port int, fd in
 
 	return conn, nil
 }
+
+// dialResult is a successful candidate from dialParallel; failures are
+// reported on a separate errs channel instead.
+type dialResult struct {
+	conn net.Conn
+	ip   net.IP
+}
+
+// dialParallel implements RFC 8305 / Happy-Eyeballs style concurrent dialing:
+// candidate IPs are interleaved across address families and dialed staggered
+// by fallbackDelay, each over its own protected socket. The first successful
+// net.Conn wins, the remaining attempts are cancelled, and a combined error
+// is returned only if every attempt failed.
+//
+// unix.Connect isn't context-aware, so an attempt already past cancellation
+// can still complete after a winner has been picked; winner is therefore
+// sized 1 and guarded by claimed so at most one goroutine ever sends to it,
+// and every other goroutine closes its own (surplus) conn instead of
+// relying on someone else to drain it later.
+func (d *ProtectedDialer) dialParallel(ctx context.Context, network v2net.Network, ips []net.IP, port int, sockopt *v2internet.SocketConfig) (net.Conn, net.IP, error) {
+	ordered := interleaveAddrs(ips, d.preferIPv6)
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	delay := d.fallbackDelay
+	if delay <= 0 {
+		delay = defaultFallbackDelay
+	}
+
+	winner := make(chan dialResult, 1)
+	errs := make(chan error, len(ordered))
+	var claimed int32
+	var wg sync.WaitGroup
+
+	for i, ip := range ordered {
+		wg.Add(1)
+		go func(i int, ip net.IP) {
+			defer wg.Done()
+
+			if i > 0 {
+				timer := time.NewTimer(time.Duration(i) * delay)
+				defer timer.Stop()
+				select {
+				case <-ctx.Done():
+					return
+				case <-timer.C:
+				}
+			}
+
+			fd, err := d.getFd(network)
+			if err != nil {
+				errs <- fmt.Errorf("%s: %w", ip, err)
+				return
+			}
+
+			conn, err := d.fdConnNetwork(ctx, network, ip, port, fd, sockopt)
+			if err != nil {
+				errs <- fmt.Errorf("%s: %w", ip, err)
+				return
+			}
+
+			if atomic.CompareAndSwapInt32(&claimed, 0, 1) {
+				winner <- dialResult{conn: conn, ip: ip}
+			} else {
+				// someone else already won; this socket is surplus.
+				conn.Close()
+			}
+		}(i, ip)
+	}
+
+	go func() {
+		wg.Wait()
+		close(winner)
+		close(errs)
+	}()
+
+	if res, ok := <-winner; ok {
+		cancel()
+		return res.conn, res.ip, nil
+	}
+
+	var combined []error
+	for err := range errs {
+		combined = append(combined, err)
+	}
+	return nil, nil, fmt.Errorf("dialParallel: all %d attempts failed: %w", len(ordered), errors.Join(combined...))
+}
+
+// interleaveAddrs sorts ips into a dial order that alternates address
+// families, starting with the preferred one, so neither family is starved
+// when both are present (mirrors clash's dualStackDialContext ordering).
+func interleaveAddrs(ips []net.IP, preferIPv6 bool) []net.IP {
+	var v4, v6 []net.IP
+	for _, ip := range ips {
+		if ip.To4() != nil {
+			v4 = append(v4, ip)
+		} else {
+			v6 = append(v6, ip)
+		}
+	}
+
+	primary, secondary := v4, v6
+	if preferIPv6 {
+		primary, secondary = v6, v4
+	}
+
+	ordered := make([]net.IP, 0, len(ips))
+	for i := 0; i < len(primary) || i < len(secondary); i++ {
+		if i < len(primary) {
+			ordered = append(ordered, primary[i])
+		}
+		if i < len(secondary) {
+			ordered = append(ordered, secondary[i])
+		}
+	}
+	return ordered
+}
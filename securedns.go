@@ -0,0 +1,365 @@
+package libv2ray
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"sync"
+	"time"
+
+	"golang.org/x/net/dns/dnsmessage"
+
+	v2net "github.com/xtls/xray-core/common/net"
+)
+
+// SecureDNSMode selects the wire protocol secureResolver speaks to the
+// configured resolver endpoint.
+type SecureDNSMode int
+
+const (
+	// SecureDNSDoH is RFC 8484 DNS-over-HTTPS (POST application/dns-message).
+	SecureDNSDoH SecureDNSMode = iota
+	// SecureDNSDoT is RFC 7858 DNS-over-TLS.
+	SecureDNSDoT
+)
+
+// SetSecureResolver switches lookupAddr to resolve over DoH or DoT instead
+// of the plain Android system resolver, so lookups don't reach the carrier
+// as plaintext DNS. Two cases still use the plain system resolver: resolving
+// a hostname endpoint's bootstrap IPs, and the fallback in
+// secureResolver.LookupIPAddr when the secure transport can't be reached.
+// endpoint is e.g. "https://1.1.1.1/dns-query" for SecureDNSDoH or
+// "tls://8.8.8.8:853" for SecureDNSDoT. The resolver's own bootstrap IPs are
+// dialed through the same Protect-ed socket path as everything else.
+func (d *ProtectedDialer) SetSecureResolver(endpoint string, mode SecureDNSMode) error {
+	sr, err := newSecureResolver(d, endpoint, mode)
+	if err != nil {
+		return err
+	}
+	d.resolver = newDNSCache(sr, defaultDNSCacheTTL)
+	return nil
+}
+
+// secureResolver is a minimal RFC 8484 / RFC 7858 client. A lookup whose
+// secure queries all fail (bad/blocked bootstrap, malformed response,
+// timeout, ...) falls back to fallback so a misconfigured secure endpoint
+// doesn't brick dialing; see LookupIPAddr for the exact rule.
+type secureResolver struct {
+	dialer    *ProtectedDialer
+	mode      SecureDNSMode
+	host      string // resolver hostname or IP literal, for SNI/Host
+	port      string
+	path      string // SecureDNSDoH only, e.g. "/dns-query" or a profile-specific path
+	bootstrap []net.IP
+	fallback  *net.Resolver
+
+	httpClient *http.Client // SecureDNSDoH only
+
+	mu       sync.Mutex
+	ttlHints map[string]time.Duration
+}
+
+func newSecureResolver(d *ProtectedDialer, endpoint string, mode SecureDNSMode) (*secureResolver, error) {
+	var rawHost, rawPort, rawPath string
+
+	switch mode {
+	case SecureDNSDoH:
+		u, err := url.Parse(endpoint)
+		if err != nil {
+			return nil, fmt.Errorf("SetSecureResolver: invalid DoH endpoint: %w", err)
+		}
+		if u.Scheme != "https" {
+			return nil, fmt.Errorf("SetSecureResolver: DoH endpoint must be https://, got %q", endpoint)
+		}
+		rawHost = u.Hostname()
+		rawPort = u.Port()
+		if rawPort == "" {
+			rawPort = "443"
+		}
+		rawPath = u.Path
+		if rawPath == "" {
+			rawPath = "/dns-query"
+		}
+	case SecureDNSDoT:
+		const prefix = "tls://"
+		addr := endpoint
+		if len(addr) >= len(prefix) && addr[:len(prefix)] == prefix {
+			addr = addr[len(prefix):]
+		}
+		var err error
+		rawHost, rawPort, err = net.SplitHostPort(addr)
+		if err != nil {
+			rawHost, rawPort = addr, "853"
+		}
+	default:
+		return nil, fmt.Errorf("SetSecureResolver: unknown SecureDNSMode %d", mode)
+	}
+
+	bootstrap, err := bootstrapResolve(rawHost)
+	if err != nil {
+		return nil, fmt.Errorf("SetSecureResolver: failed to bootstrap %s: %w", rawHost, err)
+	}
+
+	sr := &secureResolver{
+		dialer:    d,
+		mode:      mode,
+		host:      rawHost,
+		port:      rawPort,
+		path:      rawPath,
+		bootstrap: bootstrap,
+		fallback:  &net.Resolver{PreferGo: false},
+		ttlHints:  make(map[string]time.Duration),
+	}
+
+	if mode == SecureDNSDoH {
+		sr.httpClient = &http.Client{
+			Timeout: 10 * time.Second,
+			Transport: &http.Transport{
+				DialContext: sr.dialBootstrap,
+			},
+		}
+	}
+
+	return sr, nil
+}
+
+// bootstrapResolve resolves the resolver's own hostname. It deliberately
+// goes through the plain system resolver once (there is no secure resolver
+// yet to ask) unless the host is already an IP literal.
+func bootstrapResolve(host string) ([]net.IP, error) {
+	if ip := net.ParseIP(host); ip != nil {
+		return []net.IP{ip}, nil
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	addrs, err := net.DefaultResolver.LookupIPAddr(ctx, host)
+	if err != nil {
+		return nil, err
+	}
+	ips := make([]net.IP, len(addrs))
+	for i, a := range addrs {
+		ips[i] = a.IP
+	}
+	return ips, nil
+}
+
+// dialBootstrap dials one of the resolver's bootstrap IPs through the
+// Protect-ed socket path, reusing fdConn like every other outbound socket.
+// Both DoH (HTTPS) and DoT run over a plain TCP connection underneath.
+func (sr *secureResolver) dialBootstrap(ctx context.Context, _, _ string) (net.Conn, error) {
+	port, err := strconv.Atoi(sr.port)
+	if err != nil {
+		return nil, err
+	}
+
+	var lastErr error
+	for _, ip := range sr.bootstrap {
+		fd, err := sr.dialer.getFd(v2net.Network_TCP)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		conn, err := sr.dialer.fdConn(ctx, ip, port, fd, nil)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		return conn, nil
+	}
+	if lastErr == nil {
+		lastErr = fmt.Errorf("secureResolver: no bootstrap IPs for %s", sr.host)
+	}
+	return nil, lastErr
+}
+
+// LookupPort is not a DNS concern; defer to the plain resolver.
+func (sr *secureResolver) LookupPort(ctx context.Context, network, service string) (int, error) {
+	return sr.fallback.LookupPort(ctx, network, service)
+}
+
+// LookupIPAddr resolves host by querying A and AAAA over the configured
+// secure transport, merging the answers and remembering the lowest TTL seen
+// so dnsCache can honor it instead of applying its own default.
+//
+// Falling back to sr.fallback means a plaintext query reaches the carrier,
+// defeating the point of configuring secure DNS in the first place, so it's
+// reserved for when the secure transport itself couldn't be reached at all
+// (both queries errored) rather than for a secure answer that's simply
+// empty, and it's always logged.
+func (sr *secureResolver) LookupIPAddr(ctx context.Context, host string) ([]net.IPAddr, error) {
+	var (
+		ips     []net.IPAddr
+		minTTL  time.Duration
+		haveTTL bool
+		lastErr error
+		numErrs int
+	)
+
+	queryTypes := [...]dnsmessage.Type{dnsmessage.TypeA, dnsmessage.TypeAAAA}
+	for _, qtype := range queryTypes {
+		answers, err := sr.query(ctx, host, qtype)
+		if err != nil {
+			log.Printf("secureResolver: %v query for %s failed: %v", qtype, host, err)
+			lastErr = err
+			numErrs++
+			continue
+		}
+		for _, rr := range answers {
+			ip, ok := rrToIP(rr)
+			if !ok {
+				continue
+			}
+			ips = append(ips, net.IPAddr{IP: ip})
+			ttl := time.Duration(rr.Header.TTL) * time.Second
+			if !haveTTL || ttl < minTTL {
+				minTTL, haveTTL = ttl, true
+			}
+		}
+	}
+
+	if len(ips) == 0 {
+		if numErrs == len(queryTypes) {
+			// the secure transport itself is unreachable (bootstrap down,
+			// TLS failure, HTTP error, ...); fall back rather than fail the
+			// dial outright.
+			log.Printf("secureResolver: %s unreachable (%v), falling back to plaintext DNS", host, lastErr)
+			return sr.fallback.LookupIPAddr(ctx, host)
+		}
+		// at least one query reached the secure resolver and it legitimately
+		// has no records for host; that's a real answer, not a reason to
+		// leak a plaintext fallback query.
+		return nil, fmt.Errorf("domain %s: no secure DNS answer", host)
+	}
+
+	if haveTTL && minTTL > 0 {
+		sr.mu.Lock()
+		sr.ttlHints[host] = minTTL
+		sr.mu.Unlock()
+	}
+
+	return ips, nil
+}
+
+// ttlHint implements the optional interface dnsCache checks for, letting a
+// secure resolver's observed record TTL override the cache's default TTL.
+func (sr *secureResolver) ttlHint(host string) (time.Duration, bool) {
+	sr.mu.Lock()
+	defer sr.mu.Unlock()
+	ttl, ok := sr.ttlHints[host]
+	return ttl, ok
+}
+
+func (sr *secureResolver) query(ctx context.Context, host string, qtype dnsmessage.Type) ([]dnsmessage.Resource, error) {
+	name, err := dnsmessage.NewName(host + ".")
+	if err != nil {
+		return nil, err
+	}
+
+	msg := dnsmessage.Message{
+		Header: dnsmessage.Header{RecursionDesired: true},
+		Questions: []dnsmessage.Question{{
+			Name:  name,
+			Type:  qtype,
+			Class: dnsmessage.ClassINET,
+		}},
+	}
+	packed, err := msg.Pack()
+	if err != nil {
+		return nil, err
+	}
+
+	var raw []byte
+	switch sr.mode {
+	case SecureDNSDoH:
+		raw, err = sr.queryDoH(ctx, packed)
+	case SecureDNSDoT:
+		raw, err = sr.queryDoT(ctx, packed)
+	default:
+		err = fmt.Errorf("secureResolver: unknown mode %d", sr.mode)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var resp dnsmessage.Message
+	if err := resp.Unpack(raw); err != nil {
+		return nil, err
+	}
+	return resp.Answers, nil
+}
+
+func (sr *secureResolver) queryDoH(ctx context.Context, query []byte) ([]byte, error) {
+	reqURL := (&url.URL{Scheme: "https", Host: net.JoinHostPort(sr.host, sr.port), Path: sr.path}).String()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, reqURL, bytes.NewReader(query))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/dns-message")
+	req.Header.Set("Accept", "application/dns-message")
+
+	resp, err := sr.httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("secureResolver: DoH query failed: %s", resp.Status)
+	}
+	return io.ReadAll(io.LimitReader(resp.Body, 64*1024))
+}
+
+func (sr *secureResolver) queryDoT(ctx context.Context, query []byte) ([]byte, error) {
+	rawConn, err := sr.dialBootstrap(ctx, "tcp", "")
+	if err != nil {
+		return nil, err
+	}
+	defer rawConn.Close()
+
+	tlsConn := tls.Client(rawConn, &tls.Config{ServerName: sr.host})
+	if deadline, ok := ctx.Deadline(); ok {
+		tlsConn.SetDeadline(deadline)
+	}
+	if err := tlsConn.HandshakeContext(ctx); err != nil {
+		return nil, err
+	}
+	defer tlsConn.Close()
+
+	// RFC 7858 messages over TCP/TLS are prefixed with a 2-byte length.
+	var lenPrefix [2]byte
+	binary.BigEndian.PutUint16(lenPrefix[:], uint16(len(query)))
+	if _, err := tlsConn.Write(append(lenPrefix[:], query...)); err != nil {
+		return nil, err
+	}
+
+	if _, err := io.ReadFull(tlsConn, lenPrefix[:]); err != nil {
+		return nil, err
+	}
+	respLen := binary.BigEndian.Uint16(lenPrefix[:])
+	resp := make([]byte, respLen)
+	if _, err := io.ReadFull(tlsConn, resp); err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
+
+func rrToIP(rr dnsmessage.Resource) (net.IP, bool) {
+	switch body := rr.Body.(type) {
+	case *dnsmessage.AResource:
+		// body.A is 4 bytes; expand to the 16-byte v4-in-v6 form every other
+		// net.IP in this codebase carries, since fdConn copies it straight
+		// into a unix.SockaddrInet6 with no .To16() of its own.
+		return net.IP(body.A[:]).To16(), true
+	case *dnsmessage.AAAAResource:
+		return net.IP(body.AAAA[:]), true
+	default:
+		return nil, false
+	}
+}
@@ -0,0 +1,74 @@
+package libv2ray
+
+import (
+	"fmt"
+
+	"golang.org/x/sys/unix"
+	v2net "github.com/xtls/xray-core/common/net"
+	v2internet "github.com/xtls/xray-core/transport/internet"
+)
+
+// SetInterfaceName binds every protected socket to the named network
+// interface (SO_BINDTODEVICE), e.g. "wlan0" or "rmnet0". An empty name
+// (the default) leaves sockets unbound.
+func (d *ProtectedDialer) SetInterfaceName(name string) {
+	d.interfaceName = name
+}
+
+// applySocketConfig honors the parts of sockopt that getFd's bare
+// AF_INET6/SOCK_STREAM|SOCK_DGRAM socket doesn't already cover, mirroring
+// the option set clash's dialer applies before connecting. network gates the
+// IPPROTO_TCP-level options (TFO, keepalive), which fail with ENOPROTOOPT on
+// the SOCK_DGRAM fds getFd/ListenPacket hand it for v2net.Network_UDP.
+func (d *ProtectedDialer) applySocketConfig(network v2net.Network, fd int, sockopt *v2internet.SocketConfig) error {
+	// Accept v4-mapped addresses on the dual-stack AF_INET6 socket getFd
+	// always creates.
+	if err := unix.SetsockoptInt(fd, unix.IPPROTO_IPV6, unix.IPV6_V6ONLY, 0); err != nil {
+		return fmt.Errorf("applySocketConfig: IPV6_V6ONLY: %w", err)
+	}
+
+	if d.interfaceName != "" {
+		if err := unix.BindToDevice(fd, d.interfaceName); err != nil {
+			return fmt.Errorf("applySocketConfig: SO_BINDTODEVICE %s: %w", d.interfaceName, err)
+		}
+	}
+
+	if sockopt == nil {
+		return nil
+	}
+
+	if sockopt.Mark != 0 {
+		if err := unix.SetsockoptInt(fd, unix.SOL_SOCKET, unix.SO_MARK, int(sockopt.Mark)); err != nil {
+			return fmt.Errorf("applySocketConfig: SO_MARK %d: %w", sockopt.Mark, err)
+		}
+	}
+
+	if network != v2net.Network_TCP {
+		// TFO and TCP keepalive are IPPROTO_TCP-level options; setting them
+		// on a SOCK_DGRAM fd fails with ENOPROTOOPT.
+		return nil
+	}
+
+	if sockopt.Tfo == v2internet.SocketConfig_Enable {
+		if err := unix.SetsockoptInt(fd, unix.IPPROTO_TCP, unix.TCP_FASTOPEN_CONNECT, 1); err != nil {
+			return fmt.Errorf("applySocketConfig: TCP_FASTOPEN_CONNECT: %w", err)
+		}
+	}
+
+	if idle := sockopt.TcpKeepAliveIdle; idle > 0 {
+		if err := unix.SetsockoptInt(fd, unix.IPPROTO_TCP, unix.TCP_KEEPIDLE, int(idle)); err != nil {
+			return fmt.Errorf("applySocketConfig: TCP_KEEPIDLE %d: %w", idle, err)
+		}
+		if err := unix.SetsockoptInt(fd, unix.IPPROTO_TCP, unix.TCP_KEEPCNT, 3); err != nil {
+			return fmt.Errorf("applySocketConfig: TCP_KEEPCNT: %w", err)
+		}
+	}
+
+	if interval := sockopt.TcpKeepAliveInterval; interval > 0 {
+		if err := unix.SetsockoptInt(fd, unix.IPPROTO_TCP, unix.TCP_KEEPINTVL, int(interval)); err != nil {
+			return fmt.Errorf("applySocketConfig: TCP_KEEPINTVL %d: %w", interval, err)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,83 @@
+package libv2ray
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+
+	"golang.org/x/sys/unix"
+	v2net "github.com/xtls/xray-core/common/net"
+	v2internet "github.com/xtls/xray-core/transport/internet"
+)
+
+// ListenPacket opens an unconnected, protected UDP socket suitable for
+// outbound transports that need WriteTo/ReadFrom against varying peers
+// (QUIC, mKCP, DNS-over-UDP), which fdConn's connected net.FileConn can't
+// serve. address is the local address to bind to, "" or ":0" for any port
+// on the wildcard address. Mirrors the ListenPacket surface clash's dialer
+// exposes.
+func (d *ProtectedDialer) ListenPacket(ctx context.Context, network, address string, sockopt *v2internet.SocketConfig) (net.PacketConn, error) {
+	switch network {
+	case "udp", "udp4", "udp6":
+	default:
+		return nil, fmt.Errorf("ListenPacket: unsupported network %q", network)
+	}
+
+	fd, err := d.getFd(v2net.Network_UDP)
+	if err != nil {
+		return nil, err
+	}
+	defer unix.Close(fd)
+
+	if err := d.applySocketConfig(v2net.Network_UDP, fd, sockopt); err != nil {
+		return nil, err
+	}
+
+	// call android VPN service to "protect" the fd before it touches the network.
+	if !d.Protect(fd) {
+		return nil, errors.New("ListenPacket fail to protect")
+	}
+
+	sa := &unix.SockaddrInet6{}
+	if address != "" {
+		host, portStr, err := net.SplitHostPort(address)
+		if err != nil {
+			return nil, fmt.Errorf("ListenPacket: invalid address %q: %w", address, err)
+		}
+		if portStr != "" {
+			port, err := strconv.Atoi(portStr)
+			if err != nil {
+				return nil, fmt.Errorf("ListenPacket: invalid port %q: %w", portStr, err)
+			}
+			sa.Port = port
+		}
+		if host != "" {
+			ip := net.ParseIP(host)
+			if ip == nil {
+				return nil, fmt.Errorf("ListenPacket: invalid host %q", host)
+			}
+			copy(sa.Addr[:], ip.To16())
+		}
+	}
+
+	if err := unix.Bind(fd, sa); err != nil {
+		return nil, fmt.Errorf("ListenPacket: bind: %w", err)
+	}
+
+	file := os.NewFile(uintptr(fd), "Socket")
+	if file == nil {
+		return nil, errors.New("ListenPacket fd invalid")
+	}
+	defer file.Close()
+
+	// Closing pc does not affect file, and closing file does not affect pc.
+	pc, err := net.FilePacketConn(file)
+	if err != nil {
+		return nil, fmt.Errorf("ListenPacket: FilePacketConn: %w", err)
+	}
+
+	return pc, nil
+}